@@ -12,16 +12,23 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/trains-io/z21.go"
+	"github.com/trains-io/z21scan/pkg/arpscan"
+	"github.com/trains-io/z21scan/pkg/target"
 )
 
 const (
 	MAX_CONCURRENCY int = 200
+
+	// arpSweepWindow is how long the ARP prescan waits for replies before
+	// handing the live set over to the UDP probe pool.
+	arpSweepWindow = 2 * time.Second
 )
 
 var (
@@ -29,15 +36,47 @@ var (
 	output  string
 	quiet   bool
 	verbose bool
+	useArp  bool
+	live    time.Duration
+	info    bool
+
+	targetsFile string
+	exclude     string
 )
 
-var validOutputFormats = []string{"short", "normal", "verbose", "json"}
+var validOutputFormats = []string{"short", "normal", "verbose", "json", "ndjson", "prom"}
 
 type ScanResult struct {
-	IP        net.IP `json:"ip"`
-	Port      int    `json:"port"`
-	Reachable bool   `json:"reachable"`
-	Serial    string `json:"serial"`
+	IP              net.IP   `json:"ip"`
+	Port            int      `json:"port"`
+	Reachable       bool     `json:"reachable"`
+	Serial          string   `json:"serial"`
+	MAC             string   `json:"mac,omitempty"`
+	HardwareType    string   `json:"hardwareType,omitempty"`
+	FirmwareVersion string   `json:"firmwareVersion,omitempty"`
+	TrackPower      string   `json:"trackPower,omitempty"`
+	Features        []string `json:"features,omitempty"`
+}
+
+// broadcastFlagNames maps the bits of a Z21 LAN_(SET|GET)_BROADCASTFLAGS
+// flag word to their documented meaning, per the Z21 LAN protocol spec.
+// LAN_GET_BROADCASTFLAGS reports this connection's own subscription state,
+// not a device capability bitmask, so fingerprint sets every known bit
+// before reading it back (see fingerprint for the caveats that implies).
+var broadcastFlagNames = []struct {
+	bit  uint32
+	name string
+}{
+	{0x00000001, "driving-switching"},
+	{0x00000002, "rbus"},
+	{0x00000004, "railcom"},
+	{0x00000100, "system-state"},
+	{0x00010000, "driving-switching-all"},
+	{0x00020000, "loconet"},
+	{0x00040000, "loconet-all"},
+	{0x00080000, "loconet-locos"},
+	{0x01000000, "railcom-all"},
+	{0x02000000, "can-booster"},
 }
 
 func isValidOutput(val string) bool {
@@ -49,45 +88,67 @@ func isValidOutput(val string) bool {
 	return false
 }
 
-func netFromIface(name string) (*net.IPNet, error) {
-	iface, err := net.InterfaceByName(name)
-	if err != nil {
-		return nil, err
-	}
-	addrs, err := iface.Addrs()
-	if err != nil {
-		return nil, err
-	}
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
-			return ipnet, nil
+// arpPrescan sweeps every CIDR/interface target bound to a local interface
+// and returns the live hosts found, plus the subset of those networks it
+// was actually able to sweep cleanly (covered). Ranges, single IPs, and any
+// network it couldn't bind a raw socket to are left out of covered so Scan
+// falls back to probing them directly instead of silently dropping them.
+// A network that swept without error but found zero replies is also left
+// uncovered (with a diagnostic) rather than treated as "confirmed empty",
+// since a lossy or too-short ARP window is a likely cause.
+func arpPrescan(ctx context.Context, ts *target.Set) (hosts map[string]arpscan.Result, covered []*net.IPNet) {
+	hosts = make(map[string]arpscan.Result)
+
+	nets := ts.Networks()
+	if len(nets) == 0 {
+		if output == "verbose" {
+			fmt.Println("arp prescan disabled: no CIDR or interface target to bind a raw socket to")
 		}
+		return hosts, nil
 	}
-	return nil, fmt.Errorf("no IPv4 network")
-}
 
-func ipsInNet(n *net.IPNet) []net.IP {
-	var ips []net.IP
-	for ip := n.IP.Mask(n.Mask); n.Contains(ip); incIP(ip) {
-		ips = append(ips, append(net.IP(nil), ip...))
-	}
+	for _, n := range nets {
+		iface, err := arpscan.IfaceForNet(n)
+		if err != nil {
+			if output == "verbose" {
+				fmt.Printf("arp prescan disabled for %s: %v\n", n, err)
+			}
+			continue
+		}
 
-	if len(ips) > 2 {
-		return ips[1 : len(ips)-1]
+		swept, err := arpscan.Sweep(ctx, iface, ts.IPsInNetwork(n), arpSweepWindow)
+		if err != nil {
+			if output == "verbose" {
+				fmt.Printf("arp prescan disabled for %s: %v\n", n, err)
+			}
+			continue
+		}
+		if len(swept) == 0 {
+			if output == "normal" || output == "verbose" {
+				fmt.Printf("arp prescan found no replies on %s (lossy network or short window?); probing it directly instead\n", n)
+			}
+			continue
+		}
+
+		for k, v := range swept {
+			hosts[k] = v
+		}
+		covered = append(covered, n)
 	}
-	return ips
+	return hosts, covered
 }
 
-func incIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] != 0 {
-			break
+// inAnyNetwork reports whether ip falls within any of nets.
+func inAnyNetwork(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
 		}
 	}
+	return false
 }
 
-func probeUDP(ip net.IP, port int, t time.Duration) (ScanResult, error) {
+func probeUDP(ctx context.Context, ip net.IP, port int, t time.Duration) (ScanResult, error) {
 	res := ScanResult{
 		IP:   ip,
 		Port: port,
@@ -100,7 +161,7 @@ func probeUDP(ip net.IP, port int, t time.Duration) (ScanResult, error) {
 	}
 	defer conn.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), t)
+	ctx, cancel := context.WithTimeout(ctx, t)
 	defer cancel()
 
 	r, err := conn.SendRcv(ctx, &z21.SerialNumber{})
@@ -116,16 +177,245 @@ func probeUDP(ip net.IP, port int, t time.Duration) (ScanResult, error) {
 	res.Reachable = true
 	res.Serial = fmt.Sprintf("%d", m.SerialNumber)
 
+	if info {
+		fingerprint(ctx, conn, &res)
+	}
+
 	return res, nil
 }
 
+// fingerprint issues a best-effort follow-up sequence of LAN commands to
+// flesh out res beyond the serial number (--info). Each command shares ctx's
+// existing timeout budget and is skipped silently on error, so older Z21 and
+// smartRail firmwares that don't support a given command still show up with
+// whatever fields they did answer.
+func fingerprint(ctx context.Context, conn *z21.Conn, res *ScanResult) {
+	if r, err := conn.SendRcv(ctx, &z21.HardwareInfo{}); err == nil {
+		if hw, ok := r.(*z21.HardwareInfo); ok {
+			res.HardwareType = fmt.Sprintf("0x%08X", hw.HwType)
+			res.FirmwareVersion = fmt.Sprintf("%X.%02X", hw.FwVersionMajor, hw.FwVersionMinor)
+		}
+	}
+
+	if r, err := conn.SendRcv(ctx, &z21.SystemState{}); err == nil {
+		if st, ok := r.(*z21.SystemState); ok {
+			if st.TrackVoltageOff {
+				res.TrackPower = "off"
+			} else {
+				res.TrackPower = "on"
+			}
+		}
+	}
+
+	// LAN_GET_BROADCASTFLAGS reads back this connection's own subscription
+	// flags, which default to 0 on a fresh connection regardless of what
+	// the device supports. Subscribe to every known category first so the
+	// read-back has something to report; most firmware simply echoes back
+	// whatever was requested, so treat the result as "the device accepted
+	// these categories" rather than a verified capability list.
+	allFlags := uint32(0)
+	for _, f := range broadcastFlagNames {
+		allFlags |= f.bit
+	}
+	if _, err := conn.SendRcv(ctx, &z21.SetBroadcastFlags{Flags: allFlags}); err == nil {
+		if r, err := conn.SendRcv(ctx, &z21.BroadcastFlags{}); err == nil {
+			if bf, ok := r.(*z21.BroadcastFlags); ok {
+				for _, f := range broadcastFlagNames {
+					if bf.Flags&f.bit != 0 {
+						res.Features = append(res.Features, f.name)
+					}
+				}
+			}
+		}
+	}
+
+	// The Z21 LAN protocol has no command to read back a device's own
+	// IP/broadcast network configuration (it's fixed outside the LAN
+	// protocol, via DIP switches or the Z21 setup app), so that part of
+	// the original request isn't implementable here.
+}
+
+// ndjsonMu guards the shared stdout encoder used by -o ndjson so that
+// concurrent probeUDP goroutines can't interleave partial JSON objects.
+var ndjsonMu sync.Mutex
+
+func emitNDJSON(r ScanResult) {
+	ndjsonMu.Lock()
+	defer ndjsonMu.Unlock()
+	_ = json.NewEncoder(os.Stdout).Encode(r)
+}
+
+// Scan probes every host in ts for a reachable Z21 device and returns the
+// reachable ones. It honors an optional ARP prescan (--arp) and stops early
+// if ctx is canceled. onResult, if non-nil, is called for each reachable
+// device as soon as its probe completes (used by -o ndjson to stream
+// results instead of waiting for the whole scan). It's used both for the
+// one-shot default mode and, on a ticker, for --live mode.
+func Scan(ctx context.Context, ts *target.Set, port int, onResult func(ScanResult)) ([]ScanResult, error) {
+	var hosts map[string]arpscan.Result
+	var covered []*net.IPNet
+	if useArp {
+		if output == "normal" || output == "verbose" {
+			fmt.Println("ARP prescan ...")
+		}
+		hosts, covered = arpPrescan(ctx, ts)
+	}
+
+	resultsCh := make(chan ScanResult, MAX_CONCURRENCY)
+	sem := make(chan struct{}, MAX_CONCURRENCY)
+	var wg sync.WaitGroup
+
+	var results []ScanResult
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for r := range resultsCh {
+			if r.Reachable {
+				results = append(results, r)
+				if onResult != nil {
+					onResult(r)
+				}
+			}
+		}
+	}()
+
+	probe := func(ip net.IP, mac string) {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() {
+				<-sem
+			}()
+			result, _ := probeUDP(ctx, ip, port, 2*time.Second)
+			result.MAC = mac
+			if output == "verbose" {
+				fmt.Printf("Probing %-14s -> z21 device: %t\n", ip, result.Reachable)
+			}
+			resultsCh <- result
+		}()
+	}
+
+	// Dial every ARP-discovered host directly, then stream the full target
+	// list and probe whatever wasn't already covered by a clean ARP sweep
+	// (ranges, single IPs, and any network the prescan skipped or fell back
+	// on) so --arp only ever shrinks the pool, never silently drops part of
+	// a multi-target scan.
+	for _, h := range hosts {
+		probe(h.IP, h.MAC.String())
+	}
+	for ip := range ts.IPs(ctx) {
+		if inAnyNetwork(ip, covered) {
+			continue
+		}
+		probe(ip, "")
+	}
+
+	wg.Wait()
+	close(resultsCh)
+	<-collected
+
+	return results, nil
+}
+
+// scanEvent is the NDJSON shape emitted for added/removed/changed hosts in
+// --live mode.
+type scanEvent struct {
+	Event  string `json:"event"`
+	IP     net.IP `json:"ip"`
+	Serial string `json:"serial,omitempty"`
+	MAC    string `json:"mac,omitempty"`
+}
+
+func emitEvent(kind string, r ScanResult) {
+	if output == "json" || output == "ndjson" {
+		b, err := json.Marshal(scanEvent{Event: kind, IP: r.IP, Serial: r.Serial, MAC: r.MAC})
+		if err != nil {
+			return
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	sign := map[string]string{"added": "+", "removed": "-", "changed": "~"}[kind]
+	fmt.Printf("[%s] %-15s serial=%s\n", sign, r.IP, r.Serial)
+}
+
+// promText renders results as a Prometheus textfile-exposition-format
+// document suitable for node_exporter's --collector.textfile.directory.
+func promText(results []ScanResult, scanDuration time.Duration) string {
+	var b strings.Builder
+	b.WriteString("# HELP z21_device_up Whether a Z21 device answered the scan.\n")
+	b.WriteString("# TYPE z21_device_up gauge\n")
+	for _, r := range results {
+		fmt.Fprintf(&b, "z21_device_up{ip=%q,serial=%q,hw=%q} 1\n", r.IP, r.Serial, r.HardwareType)
+	}
+	b.WriteString("# HELP z21_scan_duration_seconds How long the last z21scan run took.\n")
+	b.WriteString("# TYPE z21_scan_duration_seconds gauge\n")
+	fmt.Fprintf(&b, "z21_scan_duration_seconds %f\n", scanDuration.Seconds())
+	return b.String()
+}
+
+// runLive polls Scan on an interval and emits added/removed/changed events
+// as the result set changes, until ctx is canceled.
+func runLive(ctx context.Context, ts *target.Set) error {
+	ticker := time.NewTicker(live)
+	defer ticker.Stop()
+
+	prev := make(map[string]ScanResult)
+
+	scanOnce := func() error {
+		results, err := Scan(ctx, ts, port, nil)
+		if err != nil {
+			return err
+		}
+
+		cur := make(map[string]ScanResult, len(results))
+		for _, r := range results {
+			cur[r.IP.String()] = r
+		}
+		for ip, r := range cur {
+			old, ok := prev[ip]
+			switch {
+			case !ok:
+				emitEvent("added", r)
+			case old.Serial != r.Serial:
+				emitEvent("changed", r)
+			}
+		}
+		for ip, r := range prev {
+			if _, ok := cur[ip]; !ok {
+				emitEvent("removed", r)
+			}
+		}
+		prev = cur
+		return nil
+	}
+
+	if err := scanOnce(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := scanOnce(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 var rootCmd = &cobra.Command{
-	Use:   "z21scan [IFACE|NETWORK]",
+	Use:   "z21scan [TARGETS]",
 	Short: "Scan local network for Z21 devices.",
 	Long: `z21scan scans a local network for reachable Z21 devices.
-You can specify either a network interface (e.g. "eth0") or a 
-network address in CIDR notation (e.g. "192.168.2.0/24").`,
-	Args:          cobra.ExactArgs(1),
+TARGETS is a comma-separated list of network interfaces (e.g. "eth0"),
+CIDR networks (e.g. "192.168.2.0/24"), single IPs, and hyphenated IP
+ranges (e.g. "192.168.2.10-192.168.2.40"). It may be omitted if
+--targets-file is given instead.`,
+	Args:          cobra.MaximumNArgs(1),
 	SilenceUsage:  false,
 	SilenceErrors: true,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -137,58 +427,54 @@ network address in CIDR notation (e.g. "192.168.2.0/24").`,
 		if !isValidOutput(output) {
 			return fmt.Errorf("invalid output format: %q (valid: %v)", output, validOutputFormats)
 		}
+		if live > 0 && output == "prom" {
+			return fmt.Errorf("-o prom is a one-shot textfile-exporter snapshot; it can't be combined with --live, which would reprint the HELP/TYPE preamble every tick into the same stream")
+		}
+		if len(args) == 0 && targetsFile == "" {
+			return fmt.Errorf("no targets specified: pass TARGETS or --targets-file")
+		}
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		target := args[0]
-		var netaddr *net.IPNet
-		var err error
-
-		if strings.Contains(target, "/") {
-			_, netaddr, err = net.ParseCIDR(target)
-			if err != nil {
-				return fmt.Errorf("invalid network address: %v", err)
+		ts := target.New()
+		if len(args) == 1 {
+			if err := ts.Add(args[0]); err != nil {
+				return err
 			}
-		} else {
-			netaddr, err = netFromIface(target)
-			if err != nil {
-				return fmt.Errorf("failed to get network address from interface %q: %v", target, err)
+		}
+		if targetsFile != "" {
+			if err := ts.AddFile(targetsFile); err != nil {
+				return fmt.Errorf("failed to read --targets-file: %v", err)
+			}
+		}
+		if exclude != "" {
+			if err := ts.AddExclude(exclude); err != nil {
+				return fmt.Errorf("invalid --exclude: %v", err)
 			}
 		}
 
-		ips := ipsInNet(netaddr)
-		resultsCh := make(chan ScanResult, len(ips))
-		sem := make(chan struct{}, MAX_CONCURRENCY)
-		var wg sync.WaitGroup
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		if live > 0 {
+			return runLive(ctx, ts)
+		}
 
 		if output == "normal" || output == "verbose" {
-			fmt.Printf("Scanning network %q (port: %d) ...\n", netaddr, port)
-		}
-		for _, ip := range ips {
-			wg.Add(1)
-			sem <- struct{}{}
-			go func(target net.IP) {
-				defer wg.Done()
-				defer func() {
-					<-sem
-				}()
-				result, _ := probeUDP(ip, port, 2*time.Second)
-				if output == "verbose" {
-					fmt.Printf("Probing %-14s -> z21 device: %t\n", ip, result.Reachable)
-				}
-				resultsCh <- result
-			}(ip)
+			fmt.Printf("Scanning (port: %d) ...\n", port)
 		}
 
-		wg.Wait()
-		close(resultsCh)
+		var onResult func(ScanResult)
+		if output == "ndjson" {
+			onResult = emitNDJSON
+		}
 
-		var results []ScanResult
-		for r := range resultsCh {
-			if r.Reachable {
-				results = append(results, r)
-			}
+		start := time.Now()
+		results, err := Scan(ctx, ts, port, onResult)
+		if err != nil {
+			return err
 		}
+		scanDuration := time.Since(start)
 
 		switch output {
 		case "short":
@@ -199,7 +485,20 @@ network address in CIDR notation (e.g. "192.168.2.0/24").`,
 		case "normal", "verbose":
 			fmt.Printf("Found %d Z21 device(s)\n", len(results))
 			for _, r := range results {
-				fmt.Printf("  %-15s port=%d serial=%s\n", r.IP, r.Port, r.Serial)
+				line := fmt.Sprintf("  %-15s port=%d serial=%s", r.IP, r.Port, r.Serial)
+				if r.MAC != "" {
+					line += fmt.Sprintf(" mac=%s", r.MAC)
+				}
+				if r.HardwareType != "" {
+					line += fmt.Sprintf(" hw=%s fw=%s", r.HardwareType, r.FirmwareVersion)
+				}
+				if r.TrackPower != "" {
+					line += fmt.Sprintf(" track=%s", r.TrackPower)
+				}
+				if len(r.Features) > 0 {
+					line += fmt.Sprintf(" features=%s", strings.Join(r.Features, ","))
+				}
+				fmt.Println(line)
 			}
 
 		case "json":
@@ -211,6 +510,13 @@ network address in CIDR notation (e.g. "192.168.2.0/24").`,
 				return fmt.Errorf("failed to marshall results to JSON: %v", err)
 			}
 			fmt.Println(string(b))
+
+		case "ndjson":
+			// Each device was already written to stdout by emitNDJSON as its
+			// probe completed.
+
+		case "prom":
+			fmt.Print(promText(results, scanDuration))
 		}
 		return nil
 	},
@@ -225,7 +531,12 @@ func Execute() {
 
 func init() {
 	rootCmd.Flags().IntVarP(&port, "port", "p", 21105, "UDP port to probe")
-	rootCmd.Flags().StringVarP(&output, "output", "o", "normal", "Output format: short|normal|verbose|json")
+	rootCmd.Flags().StringVarP(&output, "output", "o", "normal", "Output format: short|normal|verbose|json|ndjson|prom")
 	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Short output (same as -o short)")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output (same as -o verbose)")
+	rootCmd.Flags().BoolVar(&useArp, "arp", false, "Prescan with ARP and only probe hosts that reply (requires CAP_NET_RAW)")
+	rootCmd.Flags().DurationVar(&live, "live", 0, "Run continuously, re-scanning every interval and reporting added/removed/changed devices (e.g. 30s)")
+	rootCmd.Flags().StringVar(&targetsFile, "targets-file", "", "Read targets (one per line, same syntax as TARGETS) from this file")
+	rootCmd.Flags().StringVar(&exclude, "exclude", "", "Comma-separated CIDRs/IPs/ranges to exclude from the scan")
+	rootCmd.Flags().BoolVarP(&info, "info", "I", false, "Collect an extended hardware/firmware/track-power fingerprint from each device")
 }
@@ -0,0 +1,139 @@
+// Package arpscan implements a best-effort ARP "who-has" prescan used to
+// skip dead hosts before the (much slower) UDP Z21 probe.
+package arpscan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/arp"
+)
+
+// Result is a live host discovered during a Sweep, identified by its
+// hardware address.
+type Result struct {
+	IP  net.IP
+	MAC net.HardwareAddr
+}
+
+// IfaceForNet returns the local interface whose address is contained in n,
+// or an error if no local interface matches.
+func IfaceForNet(n *net.IPNet) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ipnet.IP.To4() != nil && n.Contains(ipnet.IP) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no local interface bound to %s", n)
+}
+
+// toNetipAddr converts ip to an IPv4 netip.Addr, the type the underlying
+// arp.Client deals in, reporting false if ip isn't a valid IPv4 address.
+func toNetipAddr(ip net.IP) (netip.Addr, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return netip.Addr{}, false
+	}
+	return netip.AddrFromSlice(ip4)
+}
+
+// wantedSet returns the set of IPv4 addresses in ips, so Sweep's reader
+// goroutine can cheaply reject replies from hosts it didn't ARP for.
+func wantedSet(ips []net.IP) map[netip.Addr]struct{} {
+	wanted := make(map[netip.Addr]struct{}, len(ips))
+	for _, ip := range ips {
+		if addr, ok := toNetipAddr(ip); ok {
+			wanted[addr] = struct{}{}
+		}
+	}
+	return wanted
+}
+
+// Sweep broadcasts an ARP request for every address in ips on iface and
+// collects replies for window before returning. It requires CAP_NET_RAW
+// (or equivalent) on the interface; callers should fall back to the
+// un-prescanned behavior when it returns an error.
+func Sweep(ctx context.Context, iface *net.Interface, ips []net.IP, window time.Duration) (map[string]Result, error) {
+	client, err := arp.Dial(iface)
+	if err != nil {
+		return nil, fmt.Errorf("open arp socket on %s: %w", iface.Name, err)
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(window)
+	client.SetDeadline(deadline)
+
+	wanted := wantedSet(ips)
+
+	live := make(map[string]Result)
+	var mu sync.Mutex
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			pkt, _, err := client.Read()
+			if err != nil {
+				return
+			}
+			if pkt.Operation != arp.OperationReply {
+				continue
+			}
+			// Ignore replies for hosts we didn't ARP for: unrelated chatter
+			// on the segment (or an excluded host) must not leak into the
+			// live set and get UDP-probed downstream.
+			if _, ok := wanted[pkt.SenderIP]; !ok {
+				continue
+			}
+			mu.Lock()
+			live[pkt.SenderIP.String()] = Result{
+				IP:  net.IP(pkt.SenderIP.AsSlice()),
+				MAC: pkt.SenderHardwareAddr,
+			}
+			mu.Unlock()
+		}
+	}()
+
+	for _, ip := range ips {
+		if ctx.Err() != nil {
+			break
+		}
+		addr, ok := toNetipAddr(ip)
+		if !ok {
+			continue
+		}
+		_ = client.Request(addr)
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-time.After(time.Until(deadline)):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	out := make(map[string]Result, len(live))
+	for k, v := range live {
+		out[k] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,29 @@
+package arpscan
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestWantedSet(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.168.2.10"),
+		net.ParseIP("192.168.2.11"),
+		net.ParseIP("::1"), // non-IPv4, must be skipped rather than included
+	}
+
+	wanted := wantedSet(ips)
+
+	for _, s := range []string{"192.168.2.10", "192.168.2.11"} {
+		if _, ok := wanted[netip.MustParseAddr(s)]; !ok {
+			t.Errorf("wantedSet missing %s", s)
+		}
+	}
+	if len(wanted) != 2 {
+		t.Errorf("len(wantedSet) = %d, want 2", len(wanted))
+	}
+	if _, ok := wanted[netip.MustParseAddr("192.168.2.99")]; ok {
+		t.Error("wantedSet contains an address that was never requested")
+	}
+}
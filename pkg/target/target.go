@@ -0,0 +1,268 @@
+// Package target parses the scan target specifications accepted by
+// z21scan's CLI (comma-separated CIDRs/interfaces, single IPs, hyphenated
+// ranges, and files of the same) into a single deduplicated, excludable
+// stream of addresses.
+package target
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+type entryKind int
+
+const (
+	kindCIDR entryKind = iota
+	kindIface
+	kindRange
+)
+
+// entry is one parsed piece of a target or exclude specification.
+type entry struct {
+	kind entryKind
+	net  *net.IPNet // set for kindCIDR/kindIface
+	from uint32     // set for kindRange (single IPs are a range of one)
+	to   uint32
+}
+
+// Set is a parsed collection of scan targets and exclusions, built up from
+// one or more specifications via Add/AddFile/AddExclude.
+type Set struct {
+	include []entry
+	exclude []entry
+}
+
+// New returns an empty target Set.
+func New() *Set {
+	return &Set{}
+}
+
+// Add parses a comma-separated target specification (CIDRs, interface
+// names, single IPs, and IP-IP ranges) and adds it to the set.
+func (s *Set) Add(spec string) error {
+	entries, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	s.include = append(s.include, entries...)
+	return nil
+}
+
+// AddExclude parses a comma-separated specification of the same forms as
+// Add and excludes any matching addresses from the set.
+func (s *Set) AddExclude(spec string) error {
+	entries, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	s.exclude = append(s.exclude, entries...)
+	return nil
+}
+
+// AddFile reads one target specification per line from path (blank lines
+// and lines starting with "#" are ignored) and adds each to the set.
+func (s *Set) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.Add(line); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Networks returns the *net.IPNet of every CIDR or interface entry in the
+// set. It's used by the optional ARP prescan, which sweeps one raw socket
+// per bound subnet rather than handling ranges or single IPs that aren't
+// attached to a local interface.
+func (s *Set) Networks() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, e := range s.include {
+		if e.net != nil {
+			nets = append(nets, e.net)
+		}
+	}
+	return nets
+}
+
+// IPsInNetwork returns every non-excluded address in n, skipping the
+// network and broadcast addresses the way the rest of z21scan does.
+func (s *Set) IPsInNetwork(n *net.IPNet) []net.IP {
+	from, to := cidrBounds(n)
+	var ips []net.IP
+	for cur := from; ; cur++ {
+		if !s.isExcluded(cur) {
+			ips = append(ips, int2ip(cur))
+		}
+		if cur == to {
+			break
+		}
+	}
+	return ips
+}
+
+// IPs streams every non-excluded, deduplicated address across all targets
+// in the set to the returned channel, closing it when exhausted or when ctx
+// is canceled. Addresses are generated lazily so that scanning a /16 (or
+// several /24s) doesn't require materializing the full address list.
+func (s *Set) IPs(ctx context.Context) <-chan net.IP {
+	out := make(chan net.IP, 64)
+	go func() {
+		defer close(out)
+		seen := make(map[uint32]struct{})
+		for _, e := range s.include {
+			from, to := e.bounds()
+			for cur := from; ; cur++ {
+				if ctx.Err() != nil {
+					return
+				}
+				if _, dup := seen[cur]; !dup && !s.isExcluded(cur) {
+					seen[cur] = struct{}{}
+					select {
+					case out <- int2ip(cur):
+					case <-ctx.Done():
+						return
+					}
+				}
+				if cur == to {
+					break
+				}
+			}
+		}
+	}()
+	return out
+}
+
+func (e entry) bounds() (uint32, uint32) {
+	if e.net != nil {
+		return cidrBounds(e.net)
+	}
+	return e.from, e.to
+}
+
+func (s *Set) isExcluded(n uint32) bool {
+	for _, e := range s.exclude {
+		from, to := e.bounds()
+		if n >= from && n <= to {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSpec(spec string) ([]entry, error) {
+	var entries []entry
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		e, err := parseOne(part)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseOne(spec string) (entry, error) {
+	if strings.Contains(spec, "/") {
+		_, n, err := net.ParseCIDR(spec)
+		if err != nil {
+			return entry{}, fmt.Errorf("invalid CIDR %q: %w", spec, err)
+		}
+		if n.IP.To4() == nil {
+			return entry{}, fmt.Errorf("invalid CIDR %q: only IPv4 targets are supported", spec)
+		}
+		return entry{kind: kindCIDR, net: n}, nil
+	}
+
+	if strings.Contains(spec, "-") {
+		parts := strings.SplitN(spec, "-", 2)
+		from := net.ParseIP(strings.TrimSpace(parts[0]))
+		to := net.ParseIP(strings.TrimSpace(parts[1]))
+		if from == nil || to == nil {
+			return entry{}, fmt.Errorf("invalid IP range %q", spec)
+		}
+		if from.To4() == nil || to.To4() == nil {
+			return entry{}, fmt.Errorf("invalid IP range %q: only IPv4 targets are supported", spec)
+		}
+		fromN, toN := ip2int(from), ip2int(to)
+		if fromN > toN {
+			return entry{}, fmt.Errorf("invalid IP range %q: start after end", spec)
+		}
+		return entry{kind: kindRange, from: fromN, to: toN}, nil
+	}
+
+	if ip := net.ParseIP(spec); ip != nil {
+		if ip.To4() == nil {
+			return entry{}, fmt.Errorf("invalid target %q: only IPv4 targets are supported", spec)
+		}
+		n := ip2int(ip)
+		return entry{kind: kindRange, from: n, to: n}, nil
+	}
+
+	n, err := netFromIface(spec)
+	if err != nil {
+		return entry{}, fmt.Errorf("invalid target %q: not a CIDR, IP, range, or interface name: %v", spec, err)
+	}
+	return entry{kind: kindIface, net: n}, nil
+}
+
+func netFromIface(name string) (*net.IPNet, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet, nil
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 network")
+}
+
+// cidrBounds returns the first and last usable address in n, excluding the
+// network and broadcast addresses when n has more than two addresses.
+func cidrBounds(n *net.IPNet) (uint32, uint32) {
+	from := ip2int(n.IP.Mask(n.Mask))
+	ones, bits := n.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	to := from + size - 1
+	if size > 2 {
+		from++
+		to--
+	}
+	return from, to
+}
+
+func ip2int(ip net.IP) uint32 {
+	ip4 := ip.To4()
+	return binary.BigEndian.Uint32(ip4)
+}
+
+func int2ip(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
@@ -0,0 +1,57 @@
+package target
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseOne(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "cidr", spec: "192.168.2.0/24"},
+		{name: "invalid cidr", spec: "192.168.2.0/33", wantErr: true},
+		{name: "ipv6 cidr rejected", spec: "2001:db8::/64", wantErr: true},
+		{name: "single ip", spec: "192.168.2.17"},
+		{name: "ipv6 address rejected", spec: "::1", wantErr: true},
+		{name: "range", spec: "192.168.2.10-192.168.2.40"},
+		{name: "range ipv6 rejected", spec: "::1-::2", wantErr: true},
+		{name: "range start after end", spec: "192.168.2.40-192.168.2.10", wantErr: true},
+		{name: "range malformed", spec: "192.168.2.10-not-an-ip", wantErr: true},
+		{name: "not a target", spec: "not-an-interface", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseOne(c.spec)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("parseOne(%q) error = %v, wantErr %v", c.spec, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestIP2IntRoundTrip(t *testing.T) {
+	for _, s := range []string{"0.0.0.0", "192.168.2.17", "255.255.255.255"} {
+		ip := net.ParseIP(s)
+		if got := int2ip(ip2int(ip)); got.String() != s {
+			t.Fatalf("int2ip(ip2int(%s)) = %s", s, got)
+		}
+	}
+}
+
+func TestCidrBounds(t *testing.T) {
+	_, n, err := net.ParseCIDR("192.168.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := cidrBounds(n)
+	if got := int2ip(from).String(); got != "192.168.2.1" {
+		t.Errorf("first usable address = %s, want 192.168.2.1", got)
+	}
+	if got := int2ip(to).String(); got != "192.168.2.254" {
+		t.Errorf("last usable address = %s, want 192.168.2.254", got)
+	}
+}